@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-fed/httpsig"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Follower is a datastore kind, alongside Entry, recording the remote
+// actors who follow this instance's outbox.
+type Follower struct {
+	ActorURL string
+	Inbox    string
+}
+
+func followerKey(c appengine.Context, actorURL string) *datastore.Key {
+	return datastore.NewKey(c, "Follower", actorURL, 0, nil)
+}
+
+// ActivityPub holds the federation subsystem's actor identity: the
+// signing key generated (or loaded) at startup and the host/username
+// used to build actor and object URLs.
+type ActivityPub struct {
+	Host       string
+	Username   string
+	PrivateKey *rsa.PrivateKey
+}
+
+// NewActivityPub loads the instance's RSA keypair from keyPath, generating
+// and persisting a new one on first run.
+func NewActivityPub(host, username, keyPath string) (*ActivityPub, error) {
+	key, err := loadOrGenerateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &ActivityPub{Host: strings.TrimRight(host, "/"), Username: username, PrivateKey: key}, nil
+}
+
+func loadOrGenerateKey(path string) (*rsa.PrivateKey, error) {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("activitypub: %s is not a valid PEM file", path)
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (ap *ActivityPub) actorURL() string  { return ap.Host + "/activitypub/actor" }
+func (ap *ActivityPub) inboxURL() string  { return ap.Host + "/activitypub/inbox" }
+func (ap *ActivityPub) outboxURL() string { return ap.Host + "/activitypub/outbox" }
+
+func (ap *ActivityPub) publicKeyPEM() string {
+	der, _ := x509.MarshalPKIXPublicKey(&ap.PrivateKey.PublicKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// noteActivity builds the Create{Note} activity an Entry is federated as.
+func (ap *ActivityPub) noteActivity(e Entry) map[string]interface{} {
+	noteID := fmt.Sprintf("%s/entry/%s", ap.Host, e.Id)
+	return map[string]interface{}{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"id":       noteID + "#create",
+		"type":     "Create",
+		"actor":    ap.actorURL(),
+		"object": map[string]interface{}{
+			"id":           noteID,
+			"type":         "Note",
+			"attributedTo": ap.actorURL(),
+			"content":      fmt.Sprintf("%s: %s", strings.Title(e.Type), e.Title),
+			"url":          e.Link,
+		},
+	}
+}
+
+// Deliver federates e to every stored Follower's inbox. Failures are
+// logged, not surfaced, so a slow or dead follower inbox never blocks
+// SaveHandler's redirect.
+func (ap *ActivityPub) Deliver(r *http.Request, e Entry) {
+	c := appengine.NewContext(r)
+	var followers []Follower
+	if _, err := datastore.NewQuery("Follower").GetAll(c, &followers); err != nil {
+		fmt.Println("ERROR (activitypub): ", err.Error())
+		return
+	}
+	body, err := json.Marshal(ap.noteActivity(e))
+	if err != nil {
+		fmt.Println("ERROR (activitypub): ", err.Error())
+		return
+	}
+	for _, f := range followers {
+		go ap.deliverTo(f.Inbox, body)
+	}
+}
+
+func (ap *ActivityPub) deliverTo(inbox string, body []byte) {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		fmt.Println("ERROR (activitypub deliver): ", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+	if err := ap.sign(req, body); err != nil {
+		fmt.Println("ERROR (activitypub sign): ", err.Error())
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("ERROR (activitypub deliver): ", err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+func (ap *ActivityPub) sign(req *http.Request, body []byte) error {
+	signer, _, err := httpsig.NewSigner(
+		[]httpsig.Algorithm{httpsig.RSA_SHA256},
+		httpsig.DigestSha256,
+		[]string{httpsig.RequestTarget, "host", "date", "digest"},
+		httpsig.Signature, 0)
+	if err != nil {
+		return err
+	}
+	return signer.SignRequest(ap.PrivateKey, ap.actorURL()+"#main-key", req, body)
+}
+
+// fetchActor dereferences an actor URL and returns its inbox and public
+// key, used to verify Follow/Undo activities and to learn where to
+// deliver future entries.
+func fetchActor(actorURL string) (inbox string, pubKey *rsa.PublicKey, err error) {
+	resp, err := http.Get(actorURL)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	var actor struct {
+		Inbox     string `json:"inbox"`
+		PublicKey struct {
+			PublicKeyPem string `json:"publicKeyPem"`
+		} `json:"publicKey"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+		return "", nil, err
+	}
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return "", nil, fmt.Errorf("activitypub: actor %s has no public key", actorURL)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return "", nil, fmt.Errorf("activitypub: actor %s's key is not RSA", actorURL)
+	}
+	return actor.Inbox, rsaKey, nil
+}
+
+// WebfingerHandler serves /.well-known/webfinger so remote servers can
+// resolve acct:username@host to this instance's actor.
+func (a *App) WebfingerHandler(w http.ResponseWriter, r *http.Request) {
+	want := fmt.Sprintf("acct:%s@%s", a.AP.Username, strings.TrimPrefix(strings.TrimPrefix(a.AP.Host, "https://"), "http://"))
+	if r.URL.Query().Get("resource") != want {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"subject": want,
+		"links": []map[string]string{
+			{"rel": "self", "type": "application/activity+json", "href": a.AP.actorURL()},
+		},
+	})
+}
+
+// ActorHandler serves this instance's Person actor document.
+func (a *App) ActorHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                a.AP.actorURL(),
+		"type":              "Person",
+		"preferredUsername": a.AP.Username,
+		"inbox":             a.AP.inboxURL(),
+		"outbox":            a.AP.outboxURL(),
+		"publicKey": map[string]string{
+			"id":           a.AP.actorURL() + "#main-key",
+			"owner":        a.AP.actorURL(),
+			"publicKeyPem": a.AP.publicKeyPEM(),
+		},
+	})
+}
+
+const outboxPageSize = 20
+
+// outboxPageRange returns the [start, end) slice bounds, newest-first,
+// of entries (out of total) that belong on the given 0-indexed page.
+// Entries are stored oldest-first, so page 0 is the tail of the slice.
+// page is untrusted input straight from a query parameter, so it's
+// clamped to 0 rather than trusted to be non-negative.
+func outboxPageRange(total, page, pageSize int) (start, end int) {
+	if page < 0 {
+		page = 0
+	}
+	start = total - (page+1)*pageSize
+	end = total - page*pageSize
+	if end > total {
+		end = total
+	}
+	if end < 0 {
+		end = 0
+	}
+	if start < 0 {
+		start = 0
+	}
+	return start, end
+}
+
+// OutboxHandler serves a page of Create{Note} activities, one per saved
+// Entry, newest first.
+func (a *App) OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.Store.Entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := 0
+	fmt.Sscanf(r.URL.Query().Get("page"), "%d", &page)
+	start, end := outboxPageRange(len(entries), page, outboxPageSize)
+
+	items := []interface{}{}
+	for i := end - 1; i >= start; i-- {
+		items = append(items, a.AP.noteActivity(entries[i]))
+	}
+	writeJSON(w, map[string]interface{}{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           fmt.Sprintf("%s?page=%d", a.AP.outboxURL(), page),
+		"type":         "OrderedCollectionPage",
+		"partOf":       a.AP.outboxURL(),
+		"orderedItems": items,
+	})
+}
+
+// InboxHandler accepts Follow and Undo activities from remote actors,
+// verified via HTTP Signatures, and stores/removes the corresponding
+// Follower.
+func (a *App) InboxHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var activity struct {
+		Type  string `json:"type"`
+		Actor string `json:"actor"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inbox, pubKey, err := fetchActor(activity.Actor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	verifier, err := httpsig.NewVerifier(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifier.Verify(pubKey, httpsig.RSA_SHA256); err != nil {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	c := appengine.NewContext(r)
+	switch activity.Type {
+	case "Follow":
+		f := Follower{ActorURL: activity.Actor, Inbox: inbox}
+		if _, err := datastore.Put(c, followerKey(c, activity.Actor), &f); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "Undo":
+		if err := datastore.Delete(c, followerKey(c, activity.Actor)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
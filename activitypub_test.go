@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestOutboxPageRange(t *testing.T) {
+	tests := []struct {
+		name                 string
+		total, page, perPage int
+		wantStart, wantEnd   int
+	}{
+		{"first page full", 20, 0, 20, 0, 20},
+		{"first page partial", 5, 0, 20, 0, 5},
+		{"second page", 45, 1, 20, 5, 25},
+		{"last partial page", 45, 2, 20, 0, 5},
+		{"page past the end", 10, 5, 20, 0, 0},
+		{"empty store", 0, 0, 20, 0, 0},
+		{"negative page", 45, -1, 20, 25, 45},
+		{"very negative page", 10, -100, 20, 0, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := outboxPageRange(tt.total, tt.page, tt.perPage)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("outboxPageRange(%d, %d, %d) = (%d, %d), want (%d, %d)",
+					tt.total, tt.page, tt.perPage, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+	"appengine/user"
+)
+
+// defaultCacheTTL is how long a provider's search result is cached when
+// config.yml doesn't set cache.ttl.
+const defaultCacheTTL = 10 * time.Minute
+
+// SearchCache caches one provider's search result at a time, keyed by
+// "provider:query", so a miss or stale entry for one provider never
+// evicts another's cached result.
+type SearchCache interface {
+	Get(c appengine.Context, provider, query string, dest interface{}) (bool, error)
+	Set(c appengine.Context, provider, query string, ttl time.Duration, src interface{}) error
+	Flush(c appengine.Context) error
+}
+
+func cacheKey(provider, query string) string { return provider + ":" + query }
+
+// memcacheSearchCache stores results in App Engine's memcache service.
+type memcacheSearchCache struct{}
+
+func (memcacheSearchCache) Get(c appengine.Context, provider, query string, dest interface{}) (bool, error) {
+	_, err := memcache.JSON.Get(c, cacheKey(provider, query), dest)
+	if err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (memcacheSearchCache) Set(c appengine.Context, provider, query string, ttl time.Duration, src interface{}) error {
+	return memcache.JSON.Set(c, &memcache.Item{Key: cacheKey(provider, query), Object: src, Expiration: ttl})
+}
+
+func (memcacheSearchCache) Flush(c appengine.Context) error {
+	return memcache.Flush(c)
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// lruSearchCache is a size-bounded in-memory SearchCache, for running
+// standalone outside App Engine where memcache isn't available.
+type lruSearchCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+// NewLRUSearchCache returns a SearchCache that keeps at most capacity
+// entries in memory, evicting the least recently used.
+func NewLRUSearchCache(capacity int) *lruSearchCache {
+	return &lruSearchCache{capacity: capacity, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *lruSearchCache) Get(c appengine.Context, provider, query string, dest interface{}) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := cacheKey(provider, query)
+	el, ok := l.items[key]
+	if !ok {
+		return false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		l.ll.Remove(el)
+		delete(l.items, key)
+		return false, nil
+	}
+	l.ll.MoveToFront(el)
+	return true, json.Unmarshal(e.value, dest)
+}
+
+func (l *lruSearchCache) Set(c appengine.Context, provider, query string, ttl time.Duration, src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := cacheKey(provider, query)
+	if el, ok := l.items[key]; ok {
+		el.Value.(*lruEntry).value = data
+		el.Value.(*lruEntry).expires = time.Now().Add(ttl)
+		l.ll.MoveToFront(el)
+		return nil
+	}
+	el := l.ll.PushFront(&lruEntry{key: key, value: data, expires: time.Now().Add(ttl)})
+	l.items[key] = el
+	if l.ll.Len() > l.capacity {
+		if oldest := l.ll.Back(); oldest != nil {
+			l.ll.Remove(oldest)
+			delete(l.items, oldest.Value.(*lruEntry).key)
+		}
+	}
+	return nil
+}
+
+func (l *lruSearchCache) Flush(c appengine.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ll = list.New()
+	l.items = map[string]*list.Element{}
+	return nil
+}
+
+// CacheFlushHandler invalidates every cached search result. Restricted to
+// App Engine admins since it's meant for use after an API schema change.
+func (a *App) CacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if !user.IsAdmin(c) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := a.Cache.Flush(c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUSearchCacheGetSetRoundTrip(t *testing.T) {
+	c := NewLRUSearchCache(2)
+
+	if err := c.Set(nil, "rt", "alien", time.Minute, map[string]string{"title": "Alien"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	var got map[string]string
+	ok, err := c.Get(nil, "rt", "alien", &got)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !ok {
+		t.Fatal("Get: want hit, got miss")
+	}
+	if got["title"] != "Alien" {
+		t.Errorf("Get: got %v, want title=Alien", got)
+	}
+}
+
+func TestLRUSearchCacheMiss(t *testing.T) {
+	c := NewLRUSearchCache(2)
+
+	var got map[string]string
+	ok, err := c.Get(nil, "rt", "nope", &got)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ok {
+		t.Fatal("Get: want miss, got hit")
+	}
+}
+
+func TestLRUSearchCacheSeparatesProviders(t *testing.T) {
+	c := NewLRUSearchCache(2)
+	c.Set(nil, "rt", "dune", time.Minute, "movie result")
+	c.Set(nil, "sp", "dune", time.Minute, "album result")
+
+	var rtResult, spResult string
+	if ok, _ := c.Get(nil, "rt", "dune", &rtResult); !ok || rtResult != "movie result" {
+		t.Errorf("rt:dune = %q, %v, want %q, true", rtResult, ok, "movie result")
+	}
+	if ok, _ := c.Get(nil, "sp", "dune", &spResult); !ok || spResult != "album result" {
+		t.Errorf("sp:dune = %q, %v, want %q, true", spResult, ok, "album result")
+	}
+}
+
+func TestLRUSearchCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRUSearchCache(2)
+	c.Set(nil, "rt", "a", time.Minute, "A")
+	c.Set(nil, "rt", "b", time.Minute, "B")
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	var s string
+	c.Get(nil, "rt", "a", &s)
+
+	c.Set(nil, "rt", "c", time.Minute, "C")
+
+	if ok, _ := c.Get(nil, "rt", "b", &s); ok {
+		t.Error("rt:b should have been evicted, but was still present")
+	}
+	if ok, _ := c.Get(nil, "rt", "a", &s); !ok {
+		t.Error("rt:a should still be cached, but was evicted")
+	}
+	if ok, _ := c.Get(nil, "rt", "c", &s); !ok {
+		t.Error("rt:c should be cached, but was not found")
+	}
+}
+
+func TestLRUSearchCacheExpires(t *testing.T) {
+	c := NewLRUSearchCache(2)
+	c.Set(nil, "rt", "stale", -time.Minute, "old")
+
+	var s string
+	if ok, _ := c.Get(nil, "rt", "stale", &s); ok {
+		t.Error("Get: want miss for expired entry, got hit")
+	}
+}
+
+func TestLRUSearchCacheFlush(t *testing.T) {
+	c := NewLRUSearchCache(2)
+	c.Set(nil, "rt", "a", time.Minute, "A")
+
+	if err := c.Flush(nil); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	var s string
+	if ok, _ := c.Get(nil, "rt", "a", &s); ok {
+		t.Error("Get: want miss after Flush, got hit")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// EntryHandler serves the canonical per-entry URL that Micropub's
+// Location header and ActivityPub's Note id/url both point at. An
+// ActivityPub client asking for the object representation gets the
+// Note; anyone else is redirected to the entry's original link.
+func (a *App) EntryHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/entry/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	entries, err := a.Store.Entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, e := range entries {
+		if e.Id != id {
+			continue
+		}
+		if a.AP != nil && wantsActivityJSON(r) {
+			writeJSON(w, a.AP.noteActivity(e)["object"])
+			return
+		}
+		http.Redirect(w, r, e.Link, http.StatusFound)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func wantsActivityJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/activity+json") || strings.Contains(accept, "application/ld+json")
+}
@@ -7,7 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
-	"sync"
+	"time"
 
 	"github.com/kylelemons/go-gypsy/yaml"
 	"github.com/shawnps/gr"
@@ -15,7 +15,6 @@ import (
 	"github.com/shawnps/sp"
 
 	"appengine"
-	"appengine/datastore"
 )
 
 var (
@@ -30,44 +29,193 @@ type Entry struct {
 	Link     string
 	ImageURL url.URL
 	Type     string
+	// ExternalID is the provider's own id for this item (e.g. a Goodreads
+	// work id), when the search result one was saved from carried one.
+	// Scrobblers that talk to that same provider need it instead of Id,
+	// which is only unique within this app's store.
+	ExternalID string
 }
 
-func parseYAML() (rtKey, grKey, grSecret string, err error) {
-	config, err := yaml.ReadFile(*configFile)
+// defaultProviderTimeout bounds how long Search waits on any one of
+// Rotten Tomatoes, Goodreads, or Spotify when config.yml doesn't set
+// provider_timeout.
+const defaultProviderTimeout = 5 * time.Second
+
+// Config holds the settings read out of config.yml.
+type Config struct {
+	RTKey             string
+	GRKey             string
+	GRSecret          string
+	ProviderTimeout   time.Duration
+	BaseURL           string
+	IndieAuthToken    string // IndieAuth token endpoint used to verify Micropub bearer tokens
+	APUsername        string // ActivityPub actor username; federation is disabled if empty
+	APKeyPath         string
+	LastfmKey         string
+	LastfmSecret      string
+	TraktClientID     string
+	TraktClientSecret string
+	CacheTTL          time.Duration
+	CacheBackend      string // "memcache" (default) or "memory"
+}
+
+func loadConfig(path string) (Config, error) {
+	config, err := yaml.ReadFile(path)
 	if err != nil {
-		return
+		return Config{}, err
 	}
-	rtKey, err = config.Get("rt")
+	rtKey, err := config.Get("rt")
 	if err != nil {
-		return
+		return Config{}, err
 	}
-	grKey, err = config.Get("gr.key")
+	grKey, err := config.Get("gr.key")
 	if err != nil {
-		return
+		return Config{}, err
 	}
-	grSecret, err = config.Get("gr.secret")
+	grSecret, err := config.Get("gr.secret")
 	if err != nil {
-		return
+		return Config{}, err
 	}
-
-	return rtKey, grKey, grSecret, nil
+	timeout := defaultProviderTimeout
+	if raw, err := config.Get("provider_timeout"); err == nil {
+		if d, err := time.ParseDuration(raw); err == nil {
+			timeout = d
+		}
+	}
+	baseURL, _ := config.Get("base_url")
+	indieAuthToken, _ := config.Get("indieauth.token_endpoint")
+	apUsername, _ := config.Get("activitypub.username")
+	apKeyPath, err := config.Get("activitypub.key_path")
+	if err != nil {
+		apKeyPath = "activitypub.pem"
+	}
+	lastfmKey, _ := config.Get("lastfm.key")
+	lastfmSecret, _ := config.Get("lastfm.secret")
+	traktClientID, _ := config.Get("trakt.client_id")
+	traktClientSecret, _ := config.Get("trakt.client_secret")
+	cacheTTL := defaultCacheTTL
+	if raw, err := config.Get("cache.ttl"); err == nil {
+		if d, err := time.ParseDuration(raw); err == nil {
+			cacheTTL = d
+		}
+	}
+	cacheBackend, err := config.Get("cache.backend")
+	if err != nil {
+		cacheBackend = "memcache"
+	}
+	return Config{
+		RTKey:             rtKey,
+		GRKey:             grKey,
+		GRSecret:          grSecret,
+		ProviderTimeout:   timeout,
+		BaseURL:           baseURL,
+		IndieAuthToken:    indieAuthToken,
+		APUsername:        apUsername,
+		APKeyPath:         apKeyPath,
+		LastfmKey:         lastfmKey,
+		LastfmSecret:      lastfmSecret,
+		TraktClientID:     traktClientID,
+		TraktClientSecret: traktClientSecret,
+		CacheTTL:          cacheTTL,
+		CacheBackend:      cacheBackend,
+	}, nil
 }
 
-func entryKey(c appengine.Context) *datastore.Key {
-	return datastore.NewKey(c, "Entry", "default_entry", 0, nil)
+// App holds everything a request handler needs: the parsed config, the
+// provider clients, and the entry store. Handlers are methods on *App so
+// they can be constructed once at startup instead of re-reading config.yml
+// (or a global entriesPath) on every request.
+type App struct {
+	Port              string
+	ProviderTimeout   time.Duration
+	BaseURL           string
+	IndieAuthToken    string
+	AP                *ActivityPub // nil when ActivityPub federation isn't configured
+	LastfmKey         string
+	LastfmSecret      string
+	TraktClientID     string
+	TraktClientSecret string
+	GRKey             string
+	GRSecret          string
+	Cache             SearchCache
+	CacheTTL          time.Duration
+	Store             EntryStore
+	RT                rtClient
+	GR                grClient
+	SP                spClient
 }
 
-func insertEntry(title, link, mediaType, imageURL string, r *http.Request) error {
-	url, err := url.Parse(imageURL)
+// NewApp loads configFile and wires up the provider clients and entry
+// store used to serve requests.
+func NewApp(configFile, entriesPath, port string) (*App, error) {
+	cfg, err := loadConfig(configFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	e := Entry{Title: title, Link: link, ImageURL: *url, Type: mediaType}
-	c := appengine.NewContext(r)
-	key := datastore.NewIncompleteKey(c, "Entry", entryKey(c))
-	_, err = datastore.Put(c, key, &e)
+	var ap *ActivityPub
+	if cfg.APUsername != "" {
+		ap, err = NewActivityPub(cfg.BaseURL, cfg.APUsername, cfg.APKeyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var cache SearchCache
+	if cfg.CacheBackend == "memory" {
+		cache = NewLRUSearchCache(256)
+	} else {
+		cache = memcacheSearchCache{}
+	}
+
+	// rtClient/grClient/spClient don't take a context, so
+	// SearchMoviesCtx and friends can only abandon a goroutine on
+	// cancellation, not cancel the HTTP request running underneath it.
+	// Bounding http.DefaultClient itself means that request (and its
+	// connection) still gets torn down instead of leaking indefinitely.
+	http.DefaultClient.Timeout = cfg.ProviderTimeout
 
-	return err
+	return &App{
+		Port:              port,
+		ProviderTimeout:   cfg.ProviderTimeout,
+		BaseURL:           cfg.BaseURL,
+		IndieAuthToken:    cfg.IndieAuthToken,
+		AP:                ap,
+		LastfmKey:         cfg.LastfmKey,
+		LastfmSecret:      cfg.LastfmSecret,
+		TraktClientID:     cfg.TraktClientID,
+		TraktClientSecret: cfg.TraktClientSecret,
+		GRKey:             cfg.GRKey,
+		GRSecret:          cfg.GRSecret,
+		Cache:             cache,
+		CacheTTL:          cfg.CacheTTL,
+		Store:             NewFileEntryStore(entriesPath),
+		RT:                rtClient{rt.RottenTomatoes{cfg.RTKey}},
+		GR:                grClient{gr.Goodreads{cfg.GRKey, cfg.GRSecret}},
+		SP:                spClient{sp.Spotify{}},
+	}, nil
+}
+
+// Routes registers the App's handlers on mux.
+func (a *App) Routes(mux *http.ServeMux) {
+	mux.HandleFunc("/", a.HomeHandler)
+	mux.HandleFunc("/search/", makeSearchHandler(a.SearchHandler))
+	mux.HandleFunc("/save", a.SaveHandler)
+	mux.HandleFunc("/list", a.ListHandler)
+	mux.HandleFunc("/remove", a.RemoveHandler)
+	mux.HandleFunc("/micropub", a.MicropubHandler)
+	mux.HandleFunc("/entry/", a.EntryHandler)
+	mux.HandleFunc("/entry/played", a.PlayedHandler)
+	mux.HandleFunc("/oauth/lastfm/callback", a.LastfmCallbackHandler)
+	mux.HandleFunc("/oauth/trakt/callback", a.TraktCallbackHandler)
+	mux.HandleFunc("/oauth/goodreads/start", a.GoodreadsStartHandler)
+	mux.HandleFunc("/oauth/goodreads/callback", a.GoodreadsCallbackHandler)
+	mux.HandleFunc("/admin/cache/flush", a.CacheFlushHandler)
+	if a.AP != nil {
+		mux.HandleFunc("/.well-known/webfinger", a.WebfingerHandler)
+		mux.HandleFunc("/activitypub/actor", a.ActorHandler)
+		mux.HandleFunc("/activitypub/outbox", a.OutboxHandler)
+		mux.HandleFunc("/activitypub/inbox", a.InboxHandler)
+	}
 }
 
 func truncate(s, suf string, l int) string {
@@ -77,50 +225,7 @@ func truncate(s, suf string, l int) string {
 	return s[:l] + suf
 }
 
-// Search Rotten Tomatoes, Goodreads, and Spotify.
-func Search(q string, rtClient rt.RottenTomatoes, grClient gr.Goodreads, spClient sp.Spotify) (m []rt.Movie, g gr.GoodreadsResponse, s sp.SearchAlbumsResponse) {
-	var wg sync.WaitGroup
-	wg.Add(3)
-	go func(q string) {
-		defer wg.Done()
-		movies, err := rtClient.SearchMovies(q)
-		if err != nil {
-			fmt.Println("ERROR (rt): ", err.Error())
-		}
-		for _, mov := range movies {
-			mov.Title = truncate(mov.Title, "...", 60)
-			m = append(m, mov)
-		}
-	}(q)
-	go func(q string) {
-		defer wg.Done()
-		books, err := grClient.SearchBooks(q)
-		if err != nil {
-			fmt.Println("ERROR (gr): ", err.Error())
-		}
-		for i, w := range books.Search.Works {
-			w.BestBook.Title = truncate(w.BestBook.Title, "...", 60)
-			books.Search.Works[i] = w
-		}
-		g = books
-	}(q)
-	go func(q string) {
-		defer wg.Done()
-		albums, err := spClient.SearchAlbums(q)
-		if err != nil {
-			fmt.Println("ERROR (sp): ", err.Error())
-		}
-		for i, a := range albums.Albums {
-			a.Name = truncate(a.Name, "...", 60)
-			albums.Albums[i] = a
-		}
-		s = albums
-	}(q)
-	wg.Wait()
-	return m, g, s
-}
-
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	t, err := template.New("index.html").ParseFiles("templates/index.html", "templates/base.html")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -134,16 +239,14 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func SearchHandler(w http.ResponseWriter, r *http.Request, query string) {
-	rtKey, grKey, grSecret, err := parseYAML()
+func (a *App) SearchHandler(w http.ResponseWriter, r *http.Request, query string) {
+	res, err := a.Search(r.Context(), r, query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	rtClient := rt.RottenTomatoes{rtKey}
-	grClient := gr.Goodreads{grKey, grSecret}
-	spClient := sp.Spotify{}
-	m, g, s := Search(query, rtClient, grClient, spClient)
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(a.CacheTTL.Seconds())))
 	// Since spotify: URIs are not trusted, have to pass a
 	// URL function to the template to use in hrefs
 	funcMap := template.FuncMap{
@@ -154,29 +257,52 @@ func SearchHandler(w http.ResponseWriter, r *http.Request, query string) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Render the template
-	err = t.ExecuteTemplate(w, "base", map[string]interface{}{"Movies": m, "Books": g, "Albums": s.Albums})
+	// Render the template: a provider whose call failed contributes its
+	// zero value plus a *Err field the template can check to show a
+	// partial-results notice instead of losing the whole page.
+	err = t.ExecuteTemplate(w, "base", map[string]interface{}{
+		"Movies":    res.Movies,
+		"MoviesErr": res.MoviesErr,
+		"Books":     res.Books,
+		"BooksErr":  res.BooksErr,
+		"Albums":    res.Albums.Albums,
+		"AlbumsErr": res.AlbumsErr,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func SaveHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) SaveHandler(w http.ResponseWriter, r *http.Request) {
 	t := r.FormValue("title")
 	l := r.FormValue("link")
 	m := r.FormValue("media_type")
-	url := r.FormValue("image_url")
-	err := insertEntry(t, l, m, url, r)
+	imageURL := r.FormValue("image_url")
+	externalID := r.FormValue("external_id")
+	e, err := a.Store.Insert(r, t, l, m, imageURL, externalID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if a.AP != nil {
+		a.AP.Deliver(r, e)
+	}
+
+	c := appengine.NewContext(r)
+	if s, err := a.scrobblerFor(c, e.Type); err != nil {
+		fmt.Println("ERROR (scrobbler): ", err.Error())
+	} else if s != nil {
+		if err := s.Scrobble(e, time.Now()); err != nil {
+			fmt.Println("ERROR (scrobbler): ", err.Error())
+		}
+	}
+
 	http.Redirect(w, r, "/list", http.StatusFound)
 }
 
-func ListHandler(w http.ResponseWriter, r *http.Request) {
-	e, err := readEntries()
+func (a *App) ListHandler(w http.ResponseWriter, r *http.Request) {
+	e, err := a.Store.Entries()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error reading entries: %v", err), http.StatusInternalServerError)
 		return
@@ -192,9 +318,9 @@ func ListHandler(w http.ResponseWriter, r *http.Request) {
 	t.ExecuteTemplate(w, "base", map[string]interface{}{"Entries": m, "Page": "list"})
 }
 
-func RemoveHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) RemoveHandler(w http.ResponseWriter, r *http.Request) {
 	i := r.FormValue("id")
-	err := removeEntry(i)
+	err := a.Store.Remove(i)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error reading entries: %v", err), http.StatusInternalServerError)
 		return
@@ -216,17 +342,26 @@ func makeSearchHandler(fn func(http.ResponseWriter, *http.Request, string)) http
 }
 
 func init() {
-	http.HandleFunc("/", HomeHandler)
+	flag.Parse()
+	app, err := NewApp(*configFile, *entriesPath, *port)
+	if err != nil {
+		fmt.Println("ERROR (config): ", err.Error())
+		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		})
+		return
+	}
+	app.Routes(http.DefaultServeMux)
 }
 
 //func main() {
 //	flag.Parse()
-//	http.HandleFunc("/", HomeHandler)
-//	http.HandleFunc("/search/", makeSearchHandler(SearchHandler))
-//	http.HandleFunc("/save", SaveHandler)
-//	http.HandleFunc("/list", ListHandler)
-//	http.HandleFunc("/remove", RemoveHandler)
-//	fmt.Println("Running on localhost:" + *port)
+//	app, err := NewApp(*configFile, *entriesPath, *port)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	app.Routes(http.DefaultServeMux)
+//	fmt.Println("Running on localhost:" + app.Port)
 //
-//	log.Fatal(http.ListenAndServe(":"+*port, nil))
+//	log.Fatal(http.ListenAndServe(":"+app.Port, nil))
 //}
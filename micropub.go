@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// micropubProperty maps a Micropub "-of" property to the Entry.Type it
+// implies. like-of has no media-specific equivalent, so it's recorded as
+// a generic "liked" entry.
+var micropubProperty = map[string]string{
+	"watch-of":  "movie",
+	"read-of":   "book",
+	"listen-of": "album",
+	"like-of":   "liked",
+}
+
+// MicropubHandler implements the subset of the W3C Micropub spec needed
+// to create Entries from IndieWeb clients: POST to create a post, and
+// GET ?q=config / ?q=source to describe this endpoint.
+func (a *App) MicropubHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.micropubQuery(w, r)
+	case http.MethodPost:
+		a.micropubCreate(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *App) micropubQuery(w http.ResponseWriter, r *http.Request) {
+	if err := a.verifyMicropubToken(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Query().Get("q") {
+	case "config":
+		writeJSON(w, map[string]interface{}{
+			"media-endpoint": "",
+		})
+	case "source":
+		writeJSON(w, map[string]interface{}{
+			"items": []interface{}{},
+		})
+	default:
+		http.Error(w, "unsupported query", http.StatusBadRequest)
+	}
+}
+
+func (a *App) micropubCreate(w http.ResponseWriter, r *http.Request) {
+	if err := a.verifyMicropubToken(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	link, mediaType, title, err := parseMicropubPost(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if link == "" {
+		http.Error(w, "missing like-of/watch-of/read-of/listen-of", http.StatusBadRequest)
+		return
+	}
+
+	e, err := a.Store.Insert(r, title, link, mediaType, "", "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/entry/%s", strings.TrimRight(a.BaseURL, "/"), e.Id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// parseMicropubPost reads a form-encoded or JSON Micropub create request
+// and returns the target URL, the Entry.Type it implies, and a title.
+func parseMicropubPost(r *http.Request) (link, mediaType, title string, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Type       []string            `json:"type"`
+			Properties map[string][]string `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return "", "", "", err
+		}
+		for prop, mt := range micropubProperty {
+			if vs, ok := body.Properties[prop]; ok && len(vs) > 0 {
+				link, mediaType = vs[0], mt
+				break
+			}
+		}
+		if vs, ok := body.Properties["name"]; ok && len(vs) > 0 {
+			title = vs[0]
+		}
+		if title == "" {
+			if vs, ok := body.Properties["content"]; ok && len(vs) > 0 {
+				title = vs[0]
+			}
+		}
+		return link, mediaType, title, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return "", "", "", err
+	}
+	for prop, mt := range micropubProperty {
+		if v := r.FormValue(prop); v != "" {
+			link, mediaType = v, mt
+			break
+		}
+	}
+	title = r.FormValue("name")
+	if title == "" {
+		title = r.FormValue("content")
+	}
+	return link, mediaType, title, nil
+}
+
+// verifyMicropubToken checks the request's Authorization: Bearer token
+// against the IndieAuth token endpoint configured in config.yml.
+func (a *App) verifyMicropubToken(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == "" || token == auth {
+		token = r.FormValue("access_token")
+	}
+	if token == "" {
+		return fmt.Errorf("missing bearer token")
+	}
+	if a.IndieAuthToken == "" {
+		return fmt.Errorf("no indieauth token endpoint configured")
+	}
+
+	req, err := http.NewRequest("GET", a.IndieAuthToken, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token endpoint rejected token: %s", resp.Status)
+	}
+
+	var verified struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verified); err != nil {
+		return err
+	}
+	if verified.Me == "" {
+		return fmt.Errorf("token endpoint did not return a verified identity")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
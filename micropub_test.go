@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseMicropubPostForm(t *testing.T) {
+	body := strings.NewReader("h=entry&watch-of=https://example.com/movie&name=A+Movie")
+	r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	link, mediaType, title, err := parseMicropubPost(r)
+	if err != nil {
+		t.Fatalf("parseMicropubPost: %v", err)
+	}
+	if link != "https://example.com/movie" || mediaType != "movie" || title != "A Movie" {
+		t.Errorf("got (%q, %q, %q), want (%q, %q, %q)",
+			link, mediaType, title, "https://example.com/movie", "movie", "A Movie")
+	}
+}
+
+func TestParseMicropubPostFormFallsBackToContent(t *testing.T) {
+	body := strings.NewReader("h=entry&read-of=https://example.com/book&content=Some+Book")
+	r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, _, title, err := parseMicropubPost(r)
+	if err != nil {
+		t.Fatalf("parseMicropubPost: %v", err)
+	}
+	if title != "Some Book" {
+		t.Errorf("title = %q, want %q", title, "Some Book")
+	}
+}
+
+func TestParseMicropubPostJSON(t *testing.T) {
+	body := strings.NewReader(`{
+		"type": ["h-entry"],
+		"properties": {
+			"listen-of": ["https://example.com/album"],
+			"name": ["An Album"]
+		}
+	}`)
+	r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+	r.Header.Set("Content-Type", "application/json")
+
+	link, mediaType, title, err := parseMicropubPost(r)
+	if err != nil {
+		t.Fatalf("parseMicropubPost: %v", err)
+	}
+	if link != "https://example.com/album" || mediaType != "album" || title != "An Album" {
+		t.Errorf("got (%q, %q, %q), want (%q, %q, %q)",
+			link, mediaType, title, "https://example.com/album", "album", "An Album")
+	}
+}
+
+func TestParseMicropubPostNoTargetProperty(t *testing.T) {
+	body := strings.NewReader("h=entry&name=Untargeted")
+	r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	link, mediaType, _, err := parseMicropubPost(r)
+	if err != nil {
+		t.Fatalf("parseMicropubPost: %v", err)
+	}
+	if link != "" || mediaType != "" {
+		t.Errorf("got link=%q mediaType=%q, want both empty", link, mediaType)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// TestOAuth1SignRFC5849AppendixA checks oauth1Sign against the worked
+// example in RFC 5849 Appendix A (the protected-resource request for
+// /photos?file=vacation.jpg&size=original, signed with an access token),
+// a test vector widely reused across OAuth 1.0 client implementations.
+func TestOAuth1SignRFC5849AppendixA(t *testing.T) {
+	params := map[string]string{
+		"oauth_consumer_key":     "dpf43f3p2l4k3l03",
+		"oauth_token":            "nnch734d00sl2jdk",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "1191242096",
+		"oauth_nonce":            "kllo9940pd9333jh",
+		"oauth_version":          "1.0",
+		"file":                   "vacation.jpg",
+		"size":                   "original",
+	}
+	got := oauth1Sign("GET", "http://photos.example.net/photos", params,
+		"kd94hf93k423kf44", "pfkkdhi9sl3r4s00")
+	want := "tR3+Ty81lMeYAr/Fid0kMTYa/WM="
+	if got != want {
+		t.Errorf("oauth1Sign() = %q, want %q", got, want)
+	}
+}
+
+func TestRFC3986EscapeSpace(t *testing.T) {
+	// The bug this guards against: url.QueryEscape turns a space into
+	// "+", which is wrong for OAuth1's signature base string.
+	if got, want := rfc3986Escape("a b"), "a%20b"; got != want {
+		t.Errorf("rfc3986Escape(%q) = %q, want %q", "a b", got, want)
+	}
+}
+
+func TestRFC3986EscapeUnreservedCharsPassThrough(t *testing.T) {
+	unreserved := "abcXYZ012-._~"
+	if got := rfc3986Escape(unreserved); got != unreserved {
+		t.Errorf("rfc3986Escape(%q) = %q, want unchanged", unreserved, got)
+	}
+}
+
+func TestRFC3986EscapeUsesUppercaseHex(t *testing.T) {
+	if got, want := rfc3986Escape(":"), "%3A"; got != want {
+		t.Errorf("rfc3986Escape(%q) = %q, want %q", ":", got, want)
+	}
+}
+
+func TestOAuth1SignChangesWithSpaceInParam(t *testing.T) {
+	base := map[string]string{"q": "hello"}
+	withSpace := map[string]string{"q": "hello world"}
+	if oauth1Sign("GET", "http://example.com/x", base, "secret", "") ==
+		oauth1Sign("GET", "http://example.com/x", withSpace, "secret", "") {
+		t.Error("oauth1Sign should produce different signatures for different param values")
+	}
+}
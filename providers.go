@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"github.com/shawnps/gr"
+	"github.com/shawnps/rt"
+	"github.com/shawnps/sp"
+)
+
+// rtClient, grClient, and spClient wrap the shawnps/{rt,gr,sp} clients,
+// none of which take a context.Context or a *http.Request, so there's no
+// way to cancel the outbound HTTP call they make internally.
+// SearchMoviesCtx and friends can still abandon the goroutine running
+// one on cancellation, but the request underneath keeps running - that
+// connection is only actually torn down by NewApp bounding
+// http.DefaultClient.Timeout to cfg.ProviderTimeout.
+type rtClient struct{ rt.RottenTomatoes }
+type grClient struct{ gr.Goodreads }
+type spClient struct{ sp.Spotify }
+
+func (c rtClient) SearchMoviesCtx(ctx context.Context, q string) ([]rt.Movie, error) {
+	type result struct {
+		movies []rt.Movie
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		movies, err := c.SearchMovies(q)
+		done <- result{movies, err}
+	}()
+	select {
+	case r := <-done:
+		return r.movies, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c grClient) SearchBooksCtx(ctx context.Context, q string) (gr.GoodreadsResponse, error) {
+	type result struct {
+		books gr.GoodreadsResponse
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		books, err := c.SearchBooks(q)
+		done <- result{books, err}
+	}()
+	select {
+	case r := <-done:
+		return r.books, r.err
+	case <-ctx.Done():
+		return gr.GoodreadsResponse{}, ctx.Err()
+	}
+}
+
+func (c spClient) SearchAlbumsCtx(ctx context.Context, q string) (sp.SearchAlbumsResponse, error) {
+	type result struct {
+		albums sp.SearchAlbumsResponse
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		albums, err := c.SearchAlbums(q)
+		done <- result{albums, err}
+	}()
+	select {
+	case r := <-done:
+		return r.albums, r.err
+	case <-ctx.Done():
+		return sp.SearchAlbumsResponse{}, ctx.Err()
+	}
+}
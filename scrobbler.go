@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Scrobbler marks an Entry as being played/watched/read, e.g. by
+// updating a media-tracking service's "now playing" status or logging a
+// completed play. Each Entry.Type is backed by a different provider.
+type Scrobbler interface {
+	NowPlaying(entry Entry) error
+	Scrobble(entry Entry, ts time.Time) error
+}
+
+// ScrobblerToken is a datastore kind, alongside Entry and Follower,
+// holding the OAuth token for one scrobbler provider.
+type ScrobblerToken struct {
+	Provider     string
+	AccessToken  string
+	AccessSecret string
+}
+
+func scrobblerTokenKey(c appengine.Context, provider string) *datastore.Key {
+	return datastore.NewKey(c, "ScrobblerToken", provider, 0, nil)
+}
+
+func loadScrobblerToken(c appengine.Context, provider string) (ScrobblerToken, error) {
+	var t ScrobblerToken
+	err := datastore.Get(c, scrobblerTokenKey(c, provider), &t)
+	if err == datastore.ErrNoSuchEntity {
+		return ScrobblerToken{Provider: provider}, nil
+	}
+	return t, err
+}
+
+func saveScrobblerToken(c appengine.Context, t ScrobblerToken) error {
+	_, err := datastore.Put(c, scrobblerTokenKey(c, t.Provider), &t)
+	return err
+}
+
+// scrobblerFor returns the Scrobbler for mediaType, or nil if there's no
+// provider for that Entry.Type.
+func (a *App) scrobblerFor(c appengine.Context, mediaType string) (Scrobbler, error) {
+	switch mediaType {
+	case "album":
+		tok, err := loadScrobblerToken(c, "lastfm")
+		if err != nil {
+			return nil, err
+		}
+		return &lastfmScrobbler{Key: a.LastfmKey, Secret: a.LastfmSecret, SessionKey: tok.AccessToken}, nil
+	case "movie":
+		tok, err := loadScrobblerToken(c, "trakt")
+		if err != nil {
+			return nil, err
+		}
+		return &traktScrobbler{ClientID: a.TraktClientID, ClientSecret: a.TraktClientSecret, AccessToken: tok.AccessToken}, nil
+	case "book":
+		tok, err := loadScrobblerToken(c, "goodreads")
+		if err != nil {
+			return nil, err
+		}
+		return &goodreadsScrobbler{Key: a.GRKey, Secret: a.GRSecret, AccessToken: tok.AccessToken, AccessSecret: tok.AccessSecret}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// lastfmScrobbler scrobbles albums to Last.fm.
+type lastfmScrobbler struct {
+	Key, Secret, SessionKey string
+}
+
+func (s *lastfmScrobbler) NowPlaying(entry Entry) error {
+	return s.call(url.Values{
+		"method": {"track.updateNowPlaying"},
+		"track":  {entry.Title},
+	})
+}
+
+func (s *lastfmScrobbler) Scrobble(entry Entry, ts time.Time) error {
+	return s.call(url.Values{
+		"method":    {"track.scrobble"},
+		"track":     {entry.Title},
+		"timestamp": {fmt.Sprintf("%d", ts.Unix())},
+	})
+}
+
+func (s *lastfmScrobbler) call(vals url.Values) error {
+	if s.SessionKey == "" {
+		return fmt.Errorf("lastfm: not authorized")
+	}
+	vals.Set("api_key", s.Key)
+	vals.Set("sk", s.SessionKey)
+	vals.Set("api_sig", lastfmSign(vals, s.Secret))
+	vals.Set("format", "json")
+
+	resp, err := http.PostForm("https://ws.audioscrobbler.com/2.0/", vals)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lastfm: %s", resp.Status)
+	}
+	return nil
+}
+
+// lastfmSign implements Last.fm's request signing scheme: every param
+// (excluding format/callback), sorted by key, concatenated as key+value,
+// then suffixed with the shared secret and MD5'd.
+func lastfmSign(vals url.Values, secret string) string {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		if k == "format" || k == "callback" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(vals.Get(k))
+	}
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// traktScrobbler scrobbles movies to Trakt.tv.
+type traktScrobbler struct {
+	ClientID, ClientSecret, AccessToken string
+}
+
+func (s *traktScrobbler) NowPlaying(entry Entry) error {
+	return s.send("start", entry)
+}
+
+func (s *traktScrobbler) Scrobble(entry Entry, ts time.Time) error {
+	return s.send("stop", entry)
+}
+
+func (s *traktScrobbler) send(action string, entry Entry) error {
+	if s.AccessToken == "" {
+		return fmt.Errorf("trakt: not authorized")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"movie":    map[string]string{"title": entry.Title},
+		"progress": 100,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://api.trakt.tv/scrobble/"+action, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", s.ClientID)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("trakt: %s", resp.Status)
+	}
+	return nil
+}
+
+// goodreadsScrobbler moves books between Goodreads shelves.
+type goodreadsScrobbler struct {
+	Key, Secret               string
+	AccessToken, AccessSecret string
+}
+
+// NowPlaying moves entry to the currently-reading shelf.
+func (s *goodreadsScrobbler) NowPlaying(entry Entry) error {
+	return s.addToShelf(entry, "currently-reading")
+}
+
+// Scrobble moves entry to the read shelf.
+func (s *goodreadsScrobbler) Scrobble(entry Entry, ts time.Time) error {
+	return s.addToShelf(entry, "read")
+}
+
+func (s *goodreadsScrobbler) addToShelf(entry Entry, shelf string) error {
+	if s.AccessToken == "" {
+		return fmt.Errorf("goodreads: not authorized")
+	}
+	if entry.ExternalID == "" {
+		return fmt.Errorf("goodreads: entry %s has no Goodreads book id", entry.Id)
+	}
+	return oauth1Post("https://www.goodreads.com/shelf/add_to_shelf.xml", s.Key, s.Secret,
+		oauth1Token{Token: s.AccessToken, Secret: s.AccessSecret},
+		map[string]string{"shelf": shelf, "book_id": entry.ExternalID})
+}
+
+// PlayedHandler records a play event for an existing entry without
+// creating a new one, e.g. for a re-watch or re-read.
+func (a *App) PlayedHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	entries, err := a.Store.Entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var e Entry
+	var found bool
+	for _, entry := range entries {
+		if entry.Id == id {
+			e, found = entry, true
+			break
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	c := appengine.NewContext(r)
+	s, err := a.scrobblerFor(c, e.Type)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		http.Error(w, "no scrobbler configured for "+e.Type, http.StatusBadRequest)
+		return
+	}
+	if err := s.Scrobble(e, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// LastfmCallbackHandler exchanges the token Last.fm's auth flow redirects
+// back with for a session key, and stores it as this instance's
+// lastfm ScrobblerToken.
+func (a *App) LastfmCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+	sessionKey, err := lastfmGetSession(a.LastfmKey, a.LastfmSecret, token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c := appengine.NewContext(r)
+	if err := saveScrobblerToken(c, ScrobblerToken{Provider: "lastfm", AccessToken: sessionKey}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/list", http.StatusFound)
+}
+
+func lastfmGetSession(key, secret, token string) (string, error) {
+	vals := url.Values{"method": {"auth.getSession"}, "api_key": {key}, "token": {token}}
+	vals.Set("api_sig", lastfmSign(vals, secret))
+	vals.Set("format", "json")
+
+	resp, err := http.Get("https://ws.audioscrobbler.com/2.0/?" + vals.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Session struct {
+			Key string `json:"key"`
+		} `json:"session"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Session.Key, nil
+}
+
+// TraktCallbackHandler exchanges an OAuth authorization code for a
+// Trakt.tv access token and stores it as this instance's trakt
+// ScrobblerToken.
+func (a *App) TraktCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "missing code", http.StatusBadRequest)
+		return
+	}
+	body, err := json.Marshal(map[string]string{
+		"code":          code,
+		"client_id":     a.TraktClientID,
+		"client_secret": a.TraktClientSecret,
+		"redirect_uri":  a.BaseURL + "/oauth/trakt/callback",
+		"grant_type":    "authorization_code",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := http.Post("https://api.trakt.tv/oauth/token", "application/json", bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c := appengine.NewContext(r)
+	if err := saveScrobblerToken(c, ScrobblerToken{Provider: "trakt", AccessToken: tok.AccessToken}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/list", http.StatusFound)
+}
+
+const (
+	goodreadsRequestTokenURL = "https://www.goodreads.com/oauth/request_token"
+	goodreadsAuthorizeURL    = "https://www.goodreads.com/oauth/authorize"
+	goodreadsAccessTokenURL  = "https://www.goodreads.com/oauth/access_token"
+)
+
+// GoodreadsStartHandler begins the OAuth 1.0 handshake: it fetches a
+// request token, stashes its secret so GoodreadsCallbackHandler can use
+// it to sign the access-token exchange, and redirects the user to
+// Goodreads to authorize it.
+func (a *App) GoodreadsStartHandler(w http.ResponseWriter, r *http.Request) {
+	reqToken, err := oauth1RequestToken(goodreadsRequestTokenURL, a.GRKey, a.GRSecret, a.BaseURL+"/oauth/goodreads/callback")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c := appengine.NewContext(r)
+	key := requestTokenKey(c, reqToken.Token)
+	if _, err := datastore.Put(c, key, &OAuthRequestToken{Secret: reqToken.Secret}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, goodreadsAuthorizeURL+"?oauth_token="+url.QueryEscape(reqToken.Token), http.StatusFound)
+}
+
+// GoodreadsCallbackHandler exchanges the now-authorized request token
+// Goodreads' authorize flow redirects back with for a real access
+// token, and stores it as this instance's goodreads ScrobblerToken.
+func (a *App) GoodreadsCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("oauth_token")
+	if token == "" {
+		http.Error(w, "missing oauth_token", http.StatusBadRequest)
+		return
+	}
+	c := appengine.NewContext(r)
+	key := requestTokenKey(c, token)
+	var reqTok OAuthRequestToken
+	if err := datastore.Get(c, key, &reqTok); err != nil {
+		http.Error(w, "unknown or expired request token", http.StatusBadRequest)
+		return
+	}
+
+	accessTok, err := oauth1AccessToken(goodreadsAccessTokenURL, a.GRKey, a.GRSecret, oauth1Token{Token: token, Secret: reqTok.Secret})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := saveScrobblerToken(c, ScrobblerToken{Provider: "goodreads", AccessToken: accessTok.Token, AccessSecret: accessTok.Secret}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	datastore.Delete(c, key)
+	http.Redirect(w, r, "/list", http.StatusFound)
+}
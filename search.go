@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/shawnps/gr"
+	"github.com/shawnps/rt"
+	"github.com/shawnps/sp"
+
+	"appengine"
+)
+
+// Result collects each provider's response alongside its own error, so
+// SearchHandler can render whichever providers made it back in time.
+type Result struct {
+	Movies    []rt.Movie
+	MoviesErr error
+
+	Books    gr.GoodreadsResponse
+	BooksErr error
+
+	Albums    sp.SearchAlbumsResponse
+	AlbumsErr error
+}
+
+// Search fans out to Rotten Tomatoes, Goodreads, and Spotify concurrently,
+// giving each provider its own deadline derived from ctx. A provider that
+// times out, or the whole search if ctx is canceled (e.g. the browser
+// disconnected), contributes its zero value and an error rather than
+// blocking the others. Each provider's result is cached independently
+// under "provider:q", so a repeat search within a.CacheTTL skips the
+// providers that are still cached even if another provider must be
+// re-fetched.
+func (a *App) Search(ctx context.Context, r *http.Request, q string) (Result, error) {
+	c := appengine.NewContext(r)
+	var (
+		wg  sync.WaitGroup
+		res Result
+	)
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		if hit, err := a.Cache.Get(c, "rt", q, &res.Movies); err == nil && hit {
+			return
+		}
+		providerCtx, cancel := context.WithTimeout(ctx, a.ProviderTimeout)
+		defer cancel()
+		movies, err := a.RT.SearchMoviesCtx(providerCtx, q)
+		if err != nil {
+			fmt.Println("ERROR (rt): ", err.Error())
+			res.MoviesErr = err
+			return
+		}
+		for _, mov := range movies {
+			mov.Title = truncate(mov.Title, "...", 60)
+			res.Movies = append(res.Movies, mov)
+		}
+		if err := a.Cache.Set(c, "rt", q, a.CacheTTL, res.Movies); err != nil {
+			fmt.Println("ERROR (cache): ", err.Error())
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if hit, err := a.Cache.Get(c, "gr", q, &res.Books); err == nil && hit {
+			return
+		}
+		providerCtx, cancel := context.WithTimeout(ctx, a.ProviderTimeout)
+		defer cancel()
+		books, err := a.GR.SearchBooksCtx(providerCtx, q)
+		if err != nil {
+			fmt.Println("ERROR (gr): ", err.Error())
+			res.BooksErr = err
+			return
+		}
+		for i, w := range books.Search.Works {
+			w.BestBook.Title = truncate(w.BestBook.Title, "...", 60)
+			books.Search.Works[i] = w
+		}
+		res.Books = books
+		if err := a.Cache.Set(c, "gr", q, a.CacheTTL, res.Books); err != nil {
+			fmt.Println("ERROR (cache): ", err.Error())
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if hit, err := a.Cache.Get(c, "sp", q, &res.Albums); err == nil && hit {
+			return
+		}
+		providerCtx, cancel := context.WithTimeout(ctx, a.ProviderTimeout)
+		defer cancel()
+		albums, err := a.SP.SearchAlbumsCtx(providerCtx, q)
+		if err != nil {
+			fmt.Println("ERROR (sp): ", err.Error())
+			res.AlbumsErr = err
+			return
+		}
+		for i, al := range albums.Albums {
+			al.Name = truncate(al.Name, "...", 60)
+			albums.Albums[i] = al
+		}
+		res.Albums = albums
+		if err := a.Cache.Set(c, "sp", q, a.CacheTTL, res.Albums); err != nil {
+			fmt.Println("ERROR (cache): ", err.Error())
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return res, nil
+	case <-ctx.Done():
+		// Each provider goroutine derives its own deadline from ctx via
+		// context.WithTimeout, so it observes this same cancellation and
+		// returns promptly. Wait for that to actually happen before
+		// reading res — res has no synchronization of its own beyond
+		// wg.Wait(), so returning it immediately here would race with
+		// goroutines still writing to it.
+		<-done
+		return res, ctx.Err()
+	}
+}
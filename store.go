@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EntryStore persists Entries; ListHandler groups what Entries returns
+// with buildEntryMap. Handlers talk to the store through this interface instead of
+// touching entriesPath or the datastore package directly, so fake stores
+// can be injected in tests.
+type EntryStore interface {
+	Insert(r *http.Request, title, link, mediaType, imageURL, externalID string) (Entry, error)
+	Entries() ([]Entry, error)
+	Remove(id string) error
+}
+
+// fileEntryStore keeps the canonical entry list as a JSON array on disk.
+// mu guards every read-modify-write of that file and of its ".seq"
+// sidecar: net/http serves concurrent requests on their own goroutines,
+// and without a lock two simultaneous Insert/Remove calls can both read
+// the same on-disk state and then each write back a version that drops
+// the other's change, or hand out the same id twice.
+type fileEntryStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileEntryStore returns an EntryStore backed by the JSON file at path.
+func NewFileEntryStore(path string) *fileEntryStore {
+	return &fileEntryStore{path: path}
+}
+
+func (s *fileEntryStore) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entriesLocked()
+}
+
+func (s *fileEntryStore) entriesLocked() ([]Entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *fileEntryStore) writeAllLocked(entries []Entry) error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entries)
+}
+
+// nextIDLocked returns a monotonically increasing id, persisted in a
+// sidecar ".seq" file next to the entries file. It must not be derived
+// from len(entries): that count shrinks on Remove, so reusing it as an
+// id would reissue an id still held by another entry. Callers must hold
+// s.mu.
+func (s *fileEntryStore) nextIDLocked() (string, error) {
+	seqPath := s.path + ".seq"
+	var n int64
+	if data, err := ioutil.ReadFile(seqPath); err == nil {
+		n, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	n++
+	if err := ioutil.WriteFile(seqPath, []byte(strconv.FormatInt(n, 10)), 0644); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func (s *fileEntryStore) Insert(r *http.Request, title, link, mediaType, imageURL, externalID string) (Entry, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.entriesLocked()
+	if err != nil {
+		return Entry{}, err
+	}
+	id, err := s.nextIDLocked()
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Id: id, Title: title, Link: link, ImageURL: *u, Type: mediaType, ExternalID: externalID}
+	entries = append(entries, e)
+	if err := s.writeAllLocked(entries); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func (s *fileEntryStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.entriesLocked()
+	if err != nil {
+		return err
+	}
+	out := entries[:0]
+	for _, e := range entries {
+		if e.Id != id {
+			out = append(out, e)
+		}
+	}
+	return s.writeAllLocked(out)
+}
+
+func buildEntryMap(entries []Entry) map[string][]Entry {
+	m := map[string][]Entry{}
+	for _, e := range entries {
+		m[e.Type] = append(m[e.Type], e)
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileEntryStoreConcurrentInsertsDontLoseEntriesOrIds(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileEntryStore(filepath.Join(dir, "entries.json"))
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/save", nil)
+			if _, err := s.Insert(r, "title", "link", "movie", "", ""); err != nil {
+				t.Errorf("Insert: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := s.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != n {
+		t.Fatalf("got %d entries, want %d (lost writes under concurrent Insert)", len(entries), n)
+	}
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.Id] {
+			t.Errorf("duplicate id %q (ids handed out to two concurrent inserts)", e.Id)
+		}
+		seen[e.Id] = true
+	}
+}
+
+func TestFileEntryStoreConcurrentRemove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "entries.json")
+	s := NewFileEntryStore(path)
+
+	r := httptest.NewRequest("POST", "/save", nil)
+	var ids []string
+	for i := 0; i < 10; i++ {
+		e, err := s.Insert(r, "title", "link", "movie", "", "")
+		if err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		ids = append(ids, e.Id)
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := s.Remove(id); err != nil {
+				t.Errorf("Remove(%q): %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	entries, err := s.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries after removing all of them, want 0", len(entries))
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("entries file missing after concurrent Remove: %v", err)
+	}
+}